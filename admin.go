@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ProxyState holds the hot-swappable parts of the data plane: the compiled
+// router and the pools it dispatches to. Reload replaces it atomically so
+// in-flight requests keep using a consistent snapshot. frontendBackends
+// holds the backend lists of L4/TLS-SNI frontends, registered once at
+// startup (those frontends don't participate in hot reload) so the admin
+// API and shutdown draining can see them too.
+type ProxyState struct {
+	mutex            sync.RWMutex
+	pools            map[string]*Pool
+	router           *Router
+	frontendBackends map[string][]*Server
+}
+
+func (ps *ProxyState) snapshot() (*Router, map[string]*Pool) {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return ps.router, ps.pools
+}
+
+func (ps *ProxyState) replace(pools map[string]*Pool, router *Router) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.pools = pools
+	ps.router = router
+}
+
+// registerFrontendBackends records the backends of an L4/TLS-SNI frontend
+// under label so they show up in allNamedServers/allServers alongside the
+// L7 pools.
+func (ps *ProxyState) registerFrontendBackends(label string, servers []*Server) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if ps.frontendBackends == nil {
+		ps.frontendBackends = make(map[string][]*Server)
+	}
+	ps.frontendBackends[label] = servers
+}
+
+// allNamedServers returns every backend across every L7 pool and registered
+// frontend, keyed by the name the admin API labels them with.
+func (ps *ProxyState) allNamedServers() map[string][]*Server {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	named := make(map[string][]*Server, len(ps.pools)+len(ps.frontendBackends))
+	for name, pool := range ps.pools {
+		named[name] = pool.Servers
+	}
+	for name, servers := range ps.frontendBackends {
+		named[name] = servers
+	}
+	return named
+}
+
+// allServers returns every backend across every pool and registered
+// frontend in the current state.
+func (ps *ProxyState) allServers() []*Server {
+	var servers []*Server
+	for _, named := range ps.allNamedServers() {
+		servers = append(servers, named...)
+	}
+	return servers
+}
+
+// AdminServer exposes operational endpoints (backend listing, manual
+// drain/up/down, config reload) on a listener separate from data-plane
+// traffic.
+type AdminServer struct {
+	state          *ProxyState
+	configPath     string
+	healthInterval time.Duration
+}
+
+// NewAdminServer builds an AdminServer that reloads configPath into state.
+func NewAdminServer(state *ProxyState, configPath string, healthInterval time.Duration) *AdminServer {
+	return &AdminServer{state: state, configPath: configPath, healthInterval: healthInterval}
+}
+
+// Handler returns the admin API's http.Handler.
+func (a *AdminServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/backends", a.handleListBackends)
+	mux.HandleFunc("/backends/drain", a.handleMark(func(s *Server) {
+		s.Mutex.Lock()
+		s.draining = true
+		s.Mutex.Unlock()
+	}))
+	mux.HandleFunc("/backends/up", a.handleMark(func(s *Server) {
+		s.Mutex.Lock()
+		s.disabled = false
+		s.draining = false
+		s.isHealthy = true
+		s.Mutex.Unlock()
+	}))
+	mux.HandleFunc("/backends/down", a.handleMark(func(s *Server) {
+		s.Mutex.Lock()
+		s.disabled = true
+		s.isHealthy = false
+		s.Mutex.Unlock()
+	}))
+	mux.HandleFunc("/reload", a.handleReload)
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+type backendStatus struct {
+	Pool     string `json:"pool"`
+	URL      string `json:"url"`
+	Healthy  bool   `json:"healthy"`
+	Draining bool   `json:"draining"`
+	Disabled bool   `json:"disabled"`
+	InFlight int64  `json:"inFlight"`
+}
+
+func (a *AdminServer) handleListBackends(w http.ResponseWriter, r *http.Request) {
+	named := a.state.allNamedServers()
+
+	statuses := make([]backendStatus, 0)
+	for name, servers := range named {
+		for _, server := range servers {
+			server.Mutex.Lock()
+			statuses = append(statuses, backendStatus{
+				Pool:     name,
+				URL:      server.URL.String(),
+				Healthy:  server.isHealthy,
+				Draining: server.draining,
+				Disabled: server.disabled,
+				InFlight: server.InFlight(),
+			})
+			server.Mutex.Unlock()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// handleMark returns a handler that applies apply to the backend named by
+// the "pool" and "server" query parameters. "pool" matches either an L7
+// pool name or a registered frontend label.
+func (a *AdminServer) handleMark(apply func(*Server)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		poolName := r.URL.Query().Get("pool")
+		serverURL := r.URL.Query().Get("server")
+		if poolName == "" || serverURL == "" {
+			http.Error(w, "pool and server query parameters are required", http.StatusBadRequest)
+			return
+		}
+
+		servers, ok := a.state.allNamedServers()[poolName]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown pool %q", poolName), http.StatusNotFound)
+			return
+		}
+		for _, server := range servers {
+			if server.URL.String() == serverURL {
+				apply(server)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		http.Error(w, fmt.Sprintf("unknown backend %q in pool %q", serverURL, poolName), http.StatusNotFound)
+	}
+}
+
+func (a *AdminServer) handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := a.Reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Reload re-reads the config file and swaps in new pools and routes,
+// reusing existing backends where possible so their health and in-flight
+// state survives, and draining-then-removing backends that disappeared.
+func (a *AdminServer) Reload() error {
+	newConfig, err := loadConfig(a.configPath)
+	if err != nil {
+		return fmt.Errorf("reload: %w", err)
+	}
+
+	interval, err := time.ParseDuration(newConfig.HealthCheckInterval)
+	if err != nil {
+		interval = a.healthInterval
+	}
+
+	_, oldPools := a.state.snapshot()
+	newPools, departed, err := reconcilePools(oldPools, newConfig, interval)
+	if err != nil {
+		return fmt.Errorf("reload: %w", err)
+	}
+
+	defaultPoolName := newConfig.DefaultPool
+	if defaultPoolName == "" {
+		defaultPoolName = "default"
+	}
+	defaultPool, ok := newPools[defaultPoolName]
+	if !ok {
+		return fmt.Errorf("reload: default pool %q not found", defaultPoolName)
+	}
+
+	router, err := NewRouter(newConfig.Routes, newPools, defaultPool)
+	if err != nil {
+		return fmt.Errorf("reload: %w", err)
+	}
+
+	a.state.replace(newPools, router)
+	log.Println("config reloaded")
+
+	go drainAndStop(departed)
+	return nil
+}
+
+// reconcilePools rebuilds the pool set described by cfg, reusing existing
+// *Server values (and their running health checkers) for backends that are
+// still present so in-flight counts and circuit-breaker state survive the
+// reload. Backends that disappeared are returned separately so the caller
+// can drain them instead of cutting their in-flight requests outright.
+func reconcilePools(oldPools map[string]*Pool, cfg Config, interval time.Duration) (map[string]*Pool, []*Server, error) {
+	poolCfgs := cfg.Pools
+	if len(poolCfgs) == 0 {
+		poolCfgs = map[string]PoolConfig{"default": {
+			Servers:      cfg.Servers,
+			Strategy:     cfg.Strategy,
+			HealthChecks: cfg.HealthChecks,
+			Persistence:  cfg.Persistence,
+		}}
+	}
+
+	var departed []*Server
+	newPools := make(map[string]*Pool, len(poolCfgs))
+
+	for name, poolCfg := range poolCfgs {
+		oldPool, existed := oldPools[name]
+
+		queueTimeout, err := parseDurationOrDefault(poolCfg.QueueTimeout, 0)
+		if err != nil {
+			return nil, nil, fmt.Errorf("pool %q: invalid queueTimeout: %w", name, err)
+		}
+		pool := &Pool{
+			Name:         name,
+			Policy:       NewBalancingPolicy(poolCfg.Strategy),
+			Persistence:  NewPersistence(poolCfg.Persistence),
+			QueueTimeout: queueTimeout,
+		}
+
+		wanted := make(map[string]bool, len(poolCfg.Servers))
+		for _, serverURL := range poolCfg.Servers {
+			wanted[serverURL] = true
+
+			reused := findServer(oldPool, serverURL)
+			if reused != nil {
+				pool.Servers = append(pool.Servers, reused)
+				continue
+			}
+
+			u, err := url.Parse(serverURL)
+			if err != nil {
+				return nil, nil, fmt.Errorf("pool %q: invalid server URL %q: %w", name, serverURL, err)
+			}
+			server := &Server{
+				URL:             u,
+				Kind:            "http",
+				isHealthy:       true,
+				stopHealthCheck: make(chan struct{}),
+				connLimiter:     newConnLimiter(poolCfg.MaxConns[serverURL]),
+			}
+			hc, err := NewHealthChecker(server, poolCfg.HealthChecks[serverURL], interval)
+			if err != nil {
+				return nil, nil, fmt.Errorf("pool %q: %w", name, err)
+			}
+			server.healthChecker = hc
+			go hc.Run(server.stopHealthCheck)
+			pool.Servers = append(pool.Servers, server)
+		}
+
+		if existed {
+			for _, s := range oldPool.Servers {
+				if !wanted[s.URL.String()] {
+					s.Mutex.Lock()
+					s.draining = true
+					s.Mutex.Unlock()
+					departed = append(departed, s)
+				}
+			}
+		}
+
+		newPools[name] = pool
+	}
+
+	// Pools removed from config entirely still need their backends drained
+	// and their health checkers stopped, same as a backend dropped from a
+	// surviving pool.
+	for name, oldPool := range oldPools {
+		if _, stillConfigured := poolCfgs[name]; stillConfigured {
+			continue
+		}
+		for _, s := range oldPool.Servers {
+			s.Mutex.Lock()
+			s.draining = true
+			s.Mutex.Unlock()
+			departed = append(departed, s)
+		}
+	}
+
+	return newPools, departed, nil
+}
+
+func findServer(pool *Pool, serverURL string) *Server {
+	if pool == nil {
+		return nil
+	}
+	for _, s := range pool.Servers {
+		if s.URL.String() == serverURL {
+			return s
+		}
+	}
+	return nil
+}
+
+// drainAndStop waits for each departed backend's in-flight count to reach
+// zero, then stops its health checker.
+func drainAndStop(servers []*Server) {
+	for _, s := range servers {
+		waitForDrain(s)
+		close(s.stopHealthCheck)
+	}
+}
+
+func waitForDrain(s *Server) {
+	if s.InFlight() == 0 {
+		return
+	}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.InFlight() == 0 {
+			return
+		}
+	}
+}
+
+// HandleShutdownSignals blocks handling SIGHUP as a reload trigger and
+// SIGTERM/SIGINT as a graceful shutdown: every L4 frontend listener is
+// closed, every backend (L7 and L4 alike) is marked draining and waited on,
+// and only then are the given HTTP servers shut down.
+func HandleShutdownSignals(admin *AdminServer, state *ProxyState, servers []*http.Server, frontends []Frontend) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+
+	for sig := range sigCh {
+		switch sig {
+		case syscall.SIGHUP:
+			log.Println("received SIGHUP, reloading config")
+			if err := admin.Reload(); err != nil {
+				log.Printf("reload failed: %v", err)
+			}
+		case syscall.SIGTERM, syscall.SIGINT:
+			log.Println("received shutdown signal, draining backends")
+			for _, f := range frontends {
+				if err := f.Close(); err != nil {
+					log.Printf("error closing frontend: %v", err)
+				}
+			}
+			drainAll(state)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			for _, srv := range servers {
+				if err := srv.Shutdown(ctx); err != nil {
+					log.Printf("error shutting down %s: %v", srv.Addr, err)
+				}
+			}
+			return
+		}
+	}
+}
+
+func drainAll(state *ProxyState) {
+	servers := state.allServers()
+	for _, s := range servers {
+		s.Mutex.Lock()
+		s.draining = true
+		s.Mutex.Unlock()
+	}
+	for _, s := range servers {
+		waitForDrain(s)
+	}
+}
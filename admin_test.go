@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fixturePool builds a Pool directly from *Server values, bypassing NewPool
+// so the test controls identity (for asserting reuse) without starting real
+// health-check goroutines.
+func fixturePool(name string, servers ...*Server) *Pool {
+	return &Pool{Name: name, Servers: servers, Policy: &RoundRobinPolicy{}}
+}
+
+func fixtureServer(rawURL string) *Server {
+	u, _ := url.Parse(rawURL)
+	return &Server{URL: u, Kind: "http", isHealthy: true, stopHealthCheck: make(chan struct{})}
+}
+
+func TestReconcilePoolsReusesSurvivingServers(t *testing.T) {
+	kept := fixtureServer("http://kept.invalid")
+	removed := fixtureServer("http://removed.invalid")
+	oldPools := map[string]*Pool{"default": fixturePool("default", kept, removed)}
+
+	cfg := Config{Pools: map[string]PoolConfig{
+		"default": {Servers: []string{"http://kept.invalid", "http://added.invalid"}},
+	}}
+
+	newPools, departed, err := reconcilePools(oldPools, cfg, time.Hour)
+	if err != nil {
+		t.Fatalf("reconcilePools: %v", err)
+	}
+	t.Cleanup(func() { closeStopChannels(newPools, departed) })
+
+	pool := newPools["default"]
+	if pool == nil {
+		t.Fatalf("expected a \"default\" pool in the result")
+	}
+	if len(pool.Servers) != 2 {
+		t.Fatalf("expected 2 servers in the reconciled pool, got %d", len(pool.Servers))
+	}
+
+	var gotKept, gotAdded *Server
+	for _, s := range pool.Servers {
+		switch s.URL.String() {
+		case "http://kept.invalid":
+			gotKept = s
+		case "http://added.invalid":
+			gotAdded = s
+		}
+	}
+	if gotKept != kept {
+		t.Fatalf("expected the surviving backend's *Server to be reused, got a new pointer")
+	}
+	if gotAdded == nil {
+		t.Fatalf("expected a new backend for the added server URL")
+	}
+
+	if len(departed) != 1 || departed[0] != removed {
+		t.Fatalf("expected exactly the removed backend to be departed, got %v", departed)
+	}
+	removed.Mutex.Lock()
+	draining := removed.draining
+	removed.Mutex.Unlock()
+	if !draining {
+		t.Fatalf("expected the removed backend to be marked draining")
+	}
+}
+
+func TestReconcilePoolsDrainsWholeRemovedPool(t *testing.T) {
+	a := fixtureServer("http://a.invalid")
+	b := fixtureServer("http://b.invalid")
+	oldPools := map[string]*Pool{
+		"default": fixturePool("default", a),
+		"gone":    fixturePool("gone", b),
+	}
+
+	cfg := Config{Pools: map[string]PoolConfig{
+		"default": {Servers: []string{"http://a.invalid"}},
+	}}
+
+	newPools, departed, err := reconcilePools(oldPools, cfg, time.Hour)
+	if err != nil {
+		t.Fatalf("reconcilePools: %v", err)
+	}
+	t.Cleanup(func() { closeStopChannels(newPools, departed) })
+
+	if _, ok := newPools["gone"]; ok {
+		t.Fatalf("expected the removed pool to be absent from the reconciled set")
+	}
+	if len(departed) != 1 || departed[0] != b {
+		t.Fatalf("expected the removed pool's backend to be departed, got %v", departed)
+	}
+	b.Mutex.Lock()
+	draining := b.draining
+	b.Mutex.Unlock()
+	if !draining {
+		t.Fatalf("expected the removed pool's backend to be marked draining")
+	}
+}
+
+// closeStopChannels closes every server's stopHealthCheck channel once, so
+// reconcilePools's newly-spawned health-check goroutines don't leak past
+// the test. Safe to call with overlapping pools/departed lists.
+func closeStopChannels(pools map[string]*Pool, departed []*Server) {
+	seen := make(map[chan struct{}]bool)
+	closeOnce := func(s *Server) {
+		if s.stopHealthCheck == nil || seen[s.stopHealthCheck] {
+			return
+		}
+		seen[s.stopHealthCheck] = true
+		close(s.stopHealthCheck)
+	}
+	for _, pool := range pools {
+		for _, s := range pool.Servers {
+			closeOnce(s)
+		}
+	}
+	for _, s := range departed {
+		closeOnce(s)
+	}
+}
+
+func TestReloadErrorsWhenDefaultPoolMissing(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	cfg := Config{
+		Pools: map[string]PoolConfig{
+			"default": {Servers: []string{"http://a.invalid"}},
+		},
+		DefaultPool: "does-not-exist",
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("marshal config: %v", err)
+	}
+	if err := os.WriteFile(configPath, data, 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	state := &ProxyState{pools: map[string]*Pool{}}
+	admin := NewAdminServer(state, configPath, time.Hour)
+
+	err = admin.Reload()
+	if err == nil {
+		t.Fatalf("expected Reload to fail when DefaultPool references an unknown pool")
+	}
+}
+
+func TestHandleMarkDrainUpDown(t *testing.T) {
+	server := fixtureServer("http://a.invalid")
+	state := &ProxyState{pools: map[string]*Pool{"default": fixturePool("default", server)}}
+	admin := NewAdminServer(state, "unused.json", time.Hour)
+
+	ts := httptest.NewServer(admin.Handler())
+	defer ts.Close()
+
+	get := func(path string) *http.Response {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		return res
+	}
+
+	res := get("/backends/drain?pool=default&server=" + url.QueryEscape("http://a.invalid"))
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("drain: expected 204, got %d", res.StatusCode)
+	}
+	if server.Selectable() {
+		t.Fatalf("a drained server must not be Selectable")
+	}
+
+	res = get("/backends/up?pool=default&server=" + url.QueryEscape("http://a.invalid"))
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("up: expected 204, got %d", res.StatusCode)
+	}
+	if !server.Selectable() {
+		t.Fatalf("expected the server to be Selectable again after /backends/up")
+	}
+
+	res = get("/backends/down?pool=default&server=" + url.QueryEscape("http://a.invalid"))
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("down: expected 204, got %d", res.StatusCode)
+	}
+	if server.Selectable() {
+		t.Fatalf("a manually disabled server must not be Selectable")
+	}
+}
+
+func TestHandleMarkUnknownPoolOrServer(t *testing.T) {
+	state := &ProxyState{pools: map[string]*Pool{"default": fixturePool("default", fixtureServer("http://a.invalid"))}}
+	admin := NewAdminServer(state, "unused.json", time.Hour)
+
+	ts := httptest.NewServer(admin.Handler())
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/backends/drain?pool=missing&server=" + url.QueryEscape("http://a.invalid"))
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown pool, got %d", res.StatusCode)
+	}
+
+	res, err = http.Get(ts.URL + "/backends/drain?pool=default&server=" + url.QueryEscape("http://missing.invalid"))
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown backend, got %d", res.StatusCode)
+	}
+}
+
+func TestHandleListBackendsIncludesFrontendBackends(t *testing.T) {
+	poolServer := fixtureServer("http://a.invalid")
+	frontendServer := fixtureServer("//tcp-backend.invalid:9000")
+	state := &ProxyState{pools: map[string]*Pool{"default": fixturePool("default", poolServer)}}
+	state.registerFrontendBackends("frontend:raw-tcp", []*Server{frontendServer})
+
+	admin := NewAdminServer(state, "unused.json", time.Hour)
+	ts := httptest.NewServer(admin.Handler())
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/backends")
+	if err != nil {
+		t.Fatalf("GET /backends: %v", err)
+	}
+	var statuses []backendStatus
+	if err := json.NewDecoder(res.Body).Decode(&statuses); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	var sawPool, sawFrontend bool
+	for _, s := range statuses {
+		if s.Pool == "default" {
+			sawPool = true
+		}
+		if s.Pool == "frontend:raw-tcp" {
+			sawFrontend = true
+		}
+	}
+	if !sawPool || !sawFrontend {
+		t.Fatalf("expected /backends to list both the L7 pool and the registered frontend backends, got %+v", statuses)
+	}
+}
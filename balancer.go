@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BalancingPolicy selects the backend that should serve a given request and
+// is notified when that request starts and finishes so that dynamic
+// strategies (least-connections, EWMA) can react to real feedback.
+type BalancingPolicy interface {
+	// Pick returns a healthy server to handle r, or nil if none is available.
+	Pick(servers []*Server, r *http.Request) *Server
+	// OnRequestStart is called right before the request is proxied to s.
+	OnRequestStart(s *Server)
+	// OnRequestEnd is called once the request has finished, with the
+	// observed latency and whether the proxy reported an error.
+	OnRequestEnd(s *Server, latency time.Duration, err error)
+}
+
+// NewBalancingPolicy builds the BalancingPolicy named by strategy, defaulting
+// to round-robin when strategy is empty or unrecognized.
+func NewBalancingPolicy(strategy string) BalancingPolicy {
+	switch strategy {
+	case "least_conn":
+		return &LeastConnPolicy{}
+	case "ewma":
+		return &EWMAPolicy{alpha: 0.3}
+	case "round_robin", "":
+		return &RoundRobinPolicy{}
+	default:
+		log.Printf("unknown strategy %q, falling back to round_robin", strategy)
+		return &RoundRobinPolicy{}
+	}
+}
+
+// RoundRobinPolicy cycles through servers in order, skipping ones that
+// aren't currently selectable. This is the original getNextServer behavior.
+type RoundRobinPolicy struct {
+	mutex   sync.Mutex
+	current int
+}
+
+func (p *RoundRobinPolicy) Pick(servers []*Server, r *http.Request) *Server {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.current >= len(servers) {
+		p.current = 0
+	}
+	for i := 0; i < len(servers); i++ {
+		server := servers[p.current]
+		p.current = (p.current + 1) % len(servers)
+
+		if server.Selectable() {
+			return server
+		}
+	}
+	return nil
+}
+
+func (p *RoundRobinPolicy) OnRequestStart(s *Server) {}
+
+func (p *RoundRobinPolicy) OnRequestEnd(s *Server, latency time.Duration, err error) {}
+
+// LeastConnPolicy routes to the selectable server with the fewest in-flight
+// requests. In-flight accounting is centralized on Server (incremented
+// before ServeHTTP, decremented in a deferred call) so it's shared with
+// admin draining and metrics.
+type LeastConnPolicy struct{}
+
+func (p *LeastConnPolicy) Pick(servers []*Server, r *http.Request) *Server {
+	var best *Server
+	var bestInFlight int64
+
+	for _, server := range servers {
+		if !server.Selectable() {
+			continue
+		}
+
+		inFlight := server.InFlight()
+		if best == nil || inFlight < bestInFlight {
+			best = server
+			bestInFlight = inFlight
+		}
+	}
+	return best
+}
+
+func (p *LeastConnPolicy) OnRequestStart(s *Server) {}
+
+func (p *LeastConnPolicy) OnRequestEnd(s *Server, latency time.Duration, err error) {}
+
+// EWMAPolicy picks the selectable server minimizing ewma*(inflight+1), where
+// ewma is an exponentially-weighted moving average of that server's observed
+// response latency: ewma = alpha*sample + (1-alpha)*ewma.
+type EWMAPolicy struct {
+	alpha float64
+}
+
+func (p *EWMAPolicy) Pick(servers []*Server, r *http.Request) *Server {
+	var best *Server
+	var bestScore float64
+
+	for _, server := range servers {
+		if !server.Selectable() {
+			continue
+		}
+
+		inFlight := float64(server.InFlight())
+		score := server.EWMA() * (inFlight + 1)
+		if best == nil || score < bestScore {
+			best = server
+			bestScore = score
+		}
+	}
+	return best
+}
+
+func (p *EWMAPolicy) OnRequestStart(s *Server) {}
+
+func (p *EWMAPolicy) OnRequestEnd(s *Server, latency time.Duration, err error) {
+	s.updateEWMA(latency, p.alpha)
+}
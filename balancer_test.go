@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func newTestServer(healthy bool) *Server {
+	return &Server{URL: &url.URL{Host: "backend.invalid"}, isHealthy: healthy}
+}
+
+func TestRoundRobinPolicySkipsUnselectable(t *testing.T) {
+	healthy := newTestServer(true)
+	down := newTestServer(false)
+	servers := []*Server{down, healthy}
+
+	p := &RoundRobinPolicy{}
+	for i := 0; i < 3; i++ {
+		if got := p.Pick(servers, nil); got != healthy {
+			t.Fatalf("Pick #%d returned %v, want the only selectable server", i, got)
+		}
+	}
+}
+
+func TestRoundRobinPolicyReturnsNilWhenNoneSelectable(t *testing.T) {
+	servers := []*Server{newTestServer(false), newTestServer(false)}
+	p := &RoundRobinPolicy{}
+	if got := p.Pick(servers, nil); got != nil {
+		t.Fatalf("expected nil when no server is selectable, got %v", got)
+	}
+}
+
+func TestLeastConnPolicyPicksFewestInFlight(t *testing.T) {
+	busy := newTestServer(true)
+	busy.inFlight = 5
+	idle := newTestServer(true)
+
+	p := &LeastConnPolicy{}
+	if got := p.Pick([]*Server{busy, idle}, nil); got != idle {
+		t.Fatalf("expected the idle server to be picked, got %v", got)
+	}
+}
+
+func TestEWMAPolicyPicksLowestScore(t *testing.T) {
+	slow := newTestServer(true)
+	slow.ewma = 1.0
+	fast := newTestServer(true)
+	fast.ewma = 0.01
+
+	p := &EWMAPolicy{alpha: 0.3}
+	if got := p.Pick([]*Server{slow, fast}, nil); got != fast {
+		t.Fatalf("expected the low-latency server to be picked, got %v", got)
+	}
+}
+
+func TestNewBalancingPolicyDefaultsToRoundRobin(t *testing.T) {
+	for _, strategy := range []string{"", "round_robin", "something-unknown"} {
+		if _, ok := NewBalancingPolicy(strategy).(*RoundRobinPolicy); !ok {
+			t.Fatalf("strategy %q: expected RoundRobinPolicy fallback", strategy)
+		}
+	}
+	if _, ok := NewBalancingPolicy("least_conn").(*LeastConnPolicy); !ok {
+		t.Fatalf("expected LeastConnPolicy for strategy \"least_conn\"")
+	}
+	if _, ok := NewBalancingPolicy("ewma").(*EWMAPolicy); !ok {
+		t.Fatalf("expected EWMAPolicy for strategy \"ewma\"")
+	}
+}
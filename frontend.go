@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Frontend is a listener GoBalancer serves traffic on. L4Frontend below
+// implements it for raw TCP and TLS-SNI passthrough; the historical HTTP
+// virtual host runs alongside as a plain http.Server wired up in main and
+// does not implement Frontend.
+type Frontend interface {
+	// Serve blocks, accepting and proxying connections until the listener
+	// is closed or an unrecoverable error occurs.
+	Serve() error
+	// Close stops accepting new connections and stops this frontend's
+	// backend health checkers. Connections already accepted are left to
+	// run; callers that want them drained should mark the backends
+	// draining (e.g. via ProxyState) before calling Close.
+	Close() error
+}
+
+// FrontendConfig describes one additional L4 listener. The existing
+// top-level Port/Servers/Strategy/HealthChecks fields continue to describe
+// the default L7 HTTP frontend.
+type FrontendConfig struct {
+	Name                string              `json:"name"`
+	Protocol            string              `json:"protocol"` // "tcp" | "tls_sni"
+	ListenAddr          string              `json:"listenAddr"`
+	Strategy            string              `json:"strategy"`
+	HealthCheckInterval string              `json:"healthCheckInterval"`
+	Servers             []string            `json:"servers"`          // backend host:port, used for protocol "tcp"
+	Routes              map[string][]string `json:"routes,omitempty"` // SNI hostname -> backend host:port, used for protocol "tls_sni"
+}
+
+// NewFrontend builds the Frontend described by cfg.
+func NewFrontend(cfg FrontendConfig) (Frontend, error) {
+	interval, err := parseDurationOrDefault(cfg.HealthCheckInterval, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid healthCheckInterval: %w", err)
+	}
+
+	switch cfg.Protocol {
+	case "tcp":
+		servers, err := newTCPServers(cfg.Servers, interval)
+		if err != nil {
+			return nil, err
+		}
+		return &L4Frontend{
+			listenAddr: cfg.ListenAddr,
+			policy:     NewBalancingPolicy(cfg.Strategy),
+			pools:      map[string][]*Server{"": servers},
+			sni:        false,
+		}, nil
+	case "tls_sni":
+		pools := make(map[string][]*Server, len(cfg.Routes))
+		for host, addrs := range cfg.Routes {
+			servers, err := newTCPServers(addrs, interval)
+			if err != nil {
+				return nil, err
+			}
+			pools[host] = servers
+		}
+		return &L4Frontend{
+			listenAddr: cfg.ListenAddr,
+			policy:     NewBalancingPolicy(cfg.Strategy),
+			pools:      pools,
+			sni:        true,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown frontend protocol %q", cfg.Protocol)
+	}
+}
+
+// newTCPServers builds the backend pool for an L4 frontend, starting a
+// plain TCP-dial health checker for each one so ejection and policy
+// selection behave the same way they do for L7 backends. Each server gets a
+// stopHealthCheck channel, closed by L4Frontend.Close, so these goroutines
+// don't outlive their frontend.
+func newTCPServers(addrs []string, healthCheckInterval time.Duration) ([]*Server, error) {
+	servers := make([]*Server, 0, len(addrs))
+	for _, addr := range addrs {
+		server := &Server{
+			URL:             &url.URL{Host: addr},
+			Address:         addr,
+			Kind:            "tcp",
+			isHealthy:       true,
+			stopHealthCheck: make(chan struct{}),
+		}
+		hc, err := NewHealthChecker(server, HealthCheckConfig{Mode: "tcp"}, healthCheckInterval)
+		if err != nil {
+			return nil, err
+		}
+		server.healthChecker = hc
+		go hc.Run(server.stopHealthCheck)
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// L4Frontend accepts raw TCP connections and forwards them byte-for-byte to
+// a backend chosen by the shared balancing-policy machinery. When sni is
+// true, the backend pool is selected by peeking the TLS ClientHello's SNI
+// hostname without terminating TLS.
+type L4Frontend struct {
+	listenAddr string
+	policy     BalancingPolicy
+	pools      map[string][]*Server // "" as the key means "no SNI routing, single pool"
+	sni        bool
+
+	mutex    sync.Mutex
+	listener net.Listener
+	closed   bool
+}
+
+func (f *L4Frontend) Serve() error {
+	ln, err := net.Listen("tcp", f.listenAddr)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	if f.closed {
+		f.mutex.Unlock()
+		ln.Close()
+		return nil
+	}
+	f.listener = ln
+	f.mutex.Unlock()
+	defer ln.Close()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go f.handle(conn)
+	}
+}
+
+// Close stops the listener and this frontend's backend health checkers. It
+// is safe to call more than once.
+func (f *L4Frontend) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+
+	var err error
+	if f.listener != nil {
+		err = f.listener.Close()
+	}
+	for _, servers := range f.pools {
+		for _, s := range servers {
+			close(s.stopHealthCheck)
+		}
+	}
+	return err
+}
+
+// Backends returns this frontend's backend pools, keyed by SNI hostname for
+// tls_sni frontends or "" for plain tcp ones, so the caller can register
+// them with ProxyState for admin-API visibility and shutdown draining.
+func (f *L4Frontend) Backends() map[string][]*Server {
+	return f.pools
+}
+
+func (f *L4Frontend) handle(conn net.Conn) {
+	defer conn.Close()
+
+	pool := f.pools[""]
+	var preamble []byte
+	if f.sni {
+		host, buffered, err := peekSNI(conn)
+		if err != nil {
+			log.Printf("tls-sni: failed to read ClientHello: %v", err)
+			return
+		}
+		preamble = buffered
+		pool = f.pools[host]
+		if pool == nil {
+			log.Printf("tls-sni: no backend pool for hostname %q", host)
+			return
+		}
+	}
+
+	backend := f.policy.Pick(pool, nil)
+	if backend == nil {
+		log.Printf("l4: no healthy backend available for %s", conn.RemoteAddr())
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", backend.Address, 5*time.Second)
+	if err != nil {
+		log.Printf("l4: failed to dial backend %s: %v", backend.Address, err)
+		upstreamConnectErrorsTotal.WithLabelValues(backend.Address).Inc()
+		backend.healthChecker.RecordPassive(0, err)
+		return
+	}
+	defer upstream.Close()
+	backend.healthChecker.RecordPassive(0, nil)
+
+	backend.incInFlight()
+	f.policy.OnRequestStart(backend)
+	start := time.Now()
+	defer func() {
+		f.policy.OnRequestEnd(backend, time.Since(start), nil)
+		backend.decInFlight()
+	}()
+
+	if len(preamble) > 0 {
+		if _, err := upstream.Write(preamble); err != nil {
+			return
+		}
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// peekSNI reads just enough of the TLS ClientHello to extract the SNI
+// server name, returning the bytes it consumed so they can be replayed to
+// the chosen backend. It assumes the ClientHello fits in a single TLS
+// record, which holds for all handshakes seen in practice.
+func peekSNI(conn net.Conn) (string, []byte, error) {
+	r := bufio.NewReader(conn)
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", nil, err
+	}
+	if header[0] != 0x16 {
+		return "", nil, fmt.Errorf("not a TLS handshake record (type %d)", header[0])
+	}
+	recordLen := binary.BigEndian.Uint16(header[3:5])
+
+	record := make([]byte, recordLen)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return "", nil, err
+	}
+
+	host, err := serverNameFromClientHello(record)
+	if err != nil {
+		return "", nil, err
+	}
+
+	buffered := append(append([]byte{}, header...), record...)
+
+	// r may have buffered more than the ClientHello in its read from the
+	// socket (a second record, pipelined data). Drain whatever's left in
+	// its buffer — no further socket read, just what's already in memory —
+	// and replay it too, so nothing is stranded when r is discarded in
+	// favor of reading conn directly from here on.
+	if n := r.Buffered(); n > 0 {
+		extra := make([]byte, n)
+		if _, err := io.ReadFull(r, extra); err != nil {
+			return "", nil, err
+		}
+		buffered = append(buffered, extra...)
+	}
+
+	return host, buffered, nil
+}
+
+// serverNameFromClientHello extracts the server_name extension's hostname
+// from the handshake body of a ClientHello TLS record.
+func serverNameFromClientHello(body []byte) (string, error) {
+	if len(body) < 4 || body[0] != 0x01 {
+		return "", fmt.Errorf("not a ClientHello")
+	}
+	pos := 4 // handshake type(1) + length(3)
+
+	pos += 2 + 32 // client_version + random
+	if pos >= len(body) {
+		return "", fmt.Errorf("truncated ClientHello")
+	}
+
+	sessionIDLen := int(body[pos])
+	pos += 1 + sessionIDLen
+
+	if pos+2 > len(body) {
+		return "", fmt.Errorf("truncated ClientHello")
+	}
+	cipherSuitesLen := int(binary.BigEndian.Uint16(body[pos : pos+2]))
+	pos += 2 + cipherSuitesLen
+
+	if pos+1 > len(body) {
+		return "", fmt.Errorf("truncated ClientHello")
+	}
+	compressionLen := int(body[pos])
+	pos += 1 + compressionLen
+
+	if pos+2 > len(body) {
+		return "", fmt.Errorf("no extensions in ClientHello")
+	}
+	extensionsLen := int(binary.BigEndian.Uint16(body[pos : pos+2]))
+	pos += 2
+	end := pos + extensionsLen
+	if end > len(body) {
+		end = len(body)
+	}
+
+	for pos+4 <= end {
+		extType := binary.BigEndian.Uint16(body[pos : pos+2])
+		extLen := int(binary.BigEndian.Uint16(body[pos+2 : pos+4]))
+		extStart := pos + 4
+		if extStart+extLen > len(body) {
+			break
+		}
+		if extType == 0x0000 { // server_name
+			return parseServerNameExtension(body[extStart : extStart+extLen])
+		}
+		pos = extStart + extLen
+	}
+	return "", fmt.Errorf("ClientHello has no server_name extension")
+}
+
+func parseServerNameExtension(data []byte) (string, error) {
+	if len(data) < 2 {
+		return "", fmt.Errorf("malformed server_name extension")
+	}
+	pos := 2 // server_name_list length
+	for pos+3 <= len(data) {
+		nameType := data[pos]
+		nameLen := int(binary.BigEndian.Uint16(data[pos+1 : pos+3]))
+		pos += 3
+		if pos+nameLen > len(data) {
+			break
+		}
+		if nameType == 0 { // host_name
+			return string(data[pos : pos+nameLen]), nil
+		}
+		pos += nameLen
+	}
+	return "", fmt.Errorf("server_name extension has no host_name entry")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// buildClientHello assembles a minimal-but-valid TLS handshake body carrying
+// a server_name extension, matching exactly what serverNameFromClientHello
+// expects to walk.
+func buildClientHello(serverName string) []byte {
+	body := []byte{0x01, 0, 0, 0} // handshake type + 3-byte length placeholder
+	body = append(body, make([]byte, 2)...)  // client_version
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, 0)                   // session_id length
+	body = append(body, 0, 0)                // cipher_suites length
+	body = append(body, 0)                   // compression_methods length
+
+	name := []byte(serverName)
+	entry := append([]byte{0}, byte(len(name)>>8), byte(len(name)))
+	entry = append(entry, name...)
+	list := append([]byte{byte(len(entry)>>8), byte(len(entry))}, entry...)
+	ext := append([]byte{0x00, 0x00}, byte(len(list)>>8), byte(len(list)))
+	ext = append(ext, list...)
+
+	body = append(body, byte(len(ext)>>8), byte(len(ext)))
+	body = append(body, ext...)
+
+	length := len(body) - 4
+	body[1] = byte(length >> 16)
+	body[2] = byte(length >> 8)
+	body[3] = byte(length)
+	return body
+}
+
+func buildTLSRecord(handshakeBody []byte) []byte {
+	header := make([]byte, 5)
+	header[0] = 0x16 // handshake
+	binary.BigEndian.PutUint16(header[3:5], uint16(len(handshakeBody)))
+	return append(header, handshakeBody...)
+}
+
+func TestServerNameFromClientHello(t *testing.T) {
+	body := buildClientHello("backend.example.com")
+	host, err := serverNameFromClientHello(body)
+	if err != nil {
+		t.Fatalf("serverNameFromClientHello: %v", err)
+	}
+	if host != "backend.example.com" {
+		t.Fatalf("got host %q, want %q", host, "backend.example.com")
+	}
+}
+
+func TestServerNameFromClientHelloRejectsNonClientHello(t *testing.T) {
+	if _, err := serverNameFromClientHello([]byte{0x02, 0, 0, 0}); err == nil {
+		t.Fatalf("expected an error for a non-ClientHello handshake type")
+	}
+}
+
+// TestPeekSNIReplaysBufferedBytes guards against the bufio.Reader used to
+// read the ClientHello record silently dropping any extra bytes (a second
+// record, pipelined application data) it pulled from the socket in the same
+// underlying Read as the ClientHello.
+func TestPeekSNIReplaysBufferedBytes(t *testing.T) {
+	record := buildTLSRecord(buildClientHello("backend.example.com"))
+	trailing := []byte("trailing-bytes-that-must-not-be-dropped")
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	go func() {
+		clientConn.Write(append(record, trailing...))
+	}()
+
+	// net.Pipe is unbuffered and synchronous, so give the writer goroutine
+	// a moment to land both the record and trailing bytes in one Read.
+	serverConn.SetReadDeadline(time.Now().Add(time.Second))
+
+	host, buffered, err := peekSNI(serverConn)
+	if err != nil {
+		t.Fatalf("peekSNI: %v", err)
+	}
+	if host != "backend.example.com" {
+		t.Fatalf("got host %q, want %q", host, "backend.example.com")
+	}
+
+	want := append(append([]byte{}, record...), trailing...)
+	if string(buffered) != string(want) {
+		t.Fatalf("buffered bytes dropped or reordered: got %d bytes, want %d bytes ending in %q", len(buffered), len(want), trailing)
+	}
+}
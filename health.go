@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// maxHealthCheckBodyBytes bounds how much of a health check response body is
+// read for the expectedBodyRegex check, so a misbehaving backend can't make
+// an active probe hang or OOM the checker.
+const maxHealthCheckBodyBytes = 1 << 20
+
+// HealthCheckConfig configures active and passive health checking for a
+// single backend. Zero values fall back to the historical behavior: an
+// HTTP HEAD request against the backend root every healthCheckInterval.
+type HealthCheckConfig struct {
+	Mode                 string `json:"mode"` // "http" | "https" | "tcp", defaults to "http"
+	Method               string `json:"method"`
+	Path                 string `json:"path"`
+	ExpectedStatusCodes  []int  `json:"expectedStatusCodes"`
+	ExpectedBodyRegex    string `json:"expectedBodyRegex"`
+	Timeout              string `json:"timeout"`
+	Interval             string `json:"interval"`
+	InsecureSkipVerify   bool   `json:"insecureSkipVerify"`
+	PassiveFailureLimit  int    `json:"passiveFailureLimit"`  // consecutive 5xx/dial errors before ejection, default 5
+	EjectBackoff         string `json:"ejectBackoff"`         // initial backoff before the first half-open probe
+	EjectMaxBackoff      string `json:"ejectMaxBackoff"`      // cap on the exponential backoff
+}
+
+// HealthChecker runs active probes against a single backend on an interval
+// and also absorbs passive signal from live proxied traffic, ejecting the
+// backend behind a circuit breaker after repeated failures.
+type HealthChecker struct {
+	server *Server
+	client *http.Client
+
+	mode                string
+	method              string
+	path                string
+	expectedStatusCodes []int
+	bodyRegex           *regexp.Regexp
+	timeout             time.Duration
+	interval            time.Duration
+
+	passiveFailureLimit int
+	ejectBackoff        time.Duration
+	ejectMaxBackoff     time.Duration
+}
+
+// NewHealthChecker builds a HealthChecker for server from cfg, applying the
+// repo's historical defaults (HTTP HEAD, 5 consecutive passive failures).
+func NewHealthChecker(server *Server, cfg HealthCheckConfig, defaultInterval time.Duration) (*HealthChecker, error) {
+	hc := &HealthChecker{
+		server:              server,
+		mode:                cfg.Mode,
+		method:              cfg.Method,
+		path:                cfg.Path,
+		expectedStatusCodes: cfg.ExpectedStatusCodes,
+		passiveFailureLimit: cfg.PassiveFailureLimit,
+	}
+	if hc.mode == "" {
+		hc.mode = "http"
+	}
+	if hc.method == "" {
+		hc.method = http.MethodHead
+	}
+	if len(hc.expectedStatusCodes) == 0 {
+		hc.expectedStatusCodes = []int{http.StatusOK}
+	}
+	if hc.passiveFailureLimit == 0 {
+		hc.passiveFailureLimit = 5
+	}
+
+	var err error
+	hc.timeout, err = parseDurationOrDefault(cfg.Timeout, 2*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid health check timeout: %w", err)
+	}
+	hc.interval, err = parseDurationOrDefault(cfg.Interval, defaultInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid health check interval: %w", err)
+	}
+	hc.ejectBackoff, err = parseDurationOrDefault(cfg.EjectBackoff, hc.interval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid eject backoff: %w", err)
+	}
+	hc.ejectMaxBackoff, err = parseDurationOrDefault(cfg.EjectMaxBackoff, 30*hc.ejectBackoff)
+	if err != nil {
+		return nil, fmt.Errorf("invalid eject max backoff: %w", err)
+	}
+
+	if cfg.ExpectedBodyRegex != "" {
+		hc.bodyRegex, err = regexp.Compile(cfg.ExpectedBodyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expected body regex: %w", err)
+		}
+	}
+
+	hc.client = &http.Client{
+		Timeout: hc.timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify},
+		},
+	}
+	return hc, nil
+}
+
+func parseDurationOrDefault(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Run fires the first active probe immediately and then on every interval,
+// until stop is closed.
+func (hc *HealthChecker) Run(stop <-chan struct{}) {
+	hc.probe()
+	ticker := time.NewTicker(hc.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			hc.probe()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// probe runs one active check, honoring an ejected backend's half-open
+// backoff, and updates the server's health accordingly.
+func (hc *HealthChecker) probe() {
+	hc.server.Mutex.Lock()
+	ejected := hc.server.ejectedUntil
+	hc.server.Mutex.Unlock()
+
+	if !ejected.IsZero() && time.Now().Before(ejected) {
+		return
+	}
+
+	err := hc.check()
+	if err != nil {
+		log.Printf("active health check failed for %s: %v", hc.server.URL, err)
+		healthCheckResultsTotal.WithLabelValues(serverID(hc.server), "failure").Inc()
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			upstreamConnectErrorsTotal.WithLabelValues(serverID(hc.server)).Inc()
+		}
+		hc.recordFailure()
+		return
+	}
+	healthCheckResultsTotal.WithLabelValues(serverID(hc.server), "success").Inc()
+	hc.recordSuccess()
+}
+
+func (hc *HealthChecker) check() error {
+	switch hc.mode {
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", hc.server.URL.Host, hc.timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	default:
+		return hc.checkHTTP()
+	}
+}
+
+func (hc *HealthChecker) checkHTTP() error {
+	target := *hc.server.URL
+	if hc.path != "" {
+		target.Path = hc.path
+	}
+
+	req, err := http.NewRequest(hc.method, target.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	statusOK := false
+	for _, code := range hc.expectedStatusCodes {
+		if res.StatusCode == code {
+			statusOK = true
+			break
+		}
+	}
+	if !statusOK {
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	if hc.bodyRegex != nil {
+		body, err := io.ReadAll(io.LimitReader(res.Body, maxHealthCheckBodyBytes))
+		if err != nil {
+			return fmt.Errorf("reading response body: %w", err)
+		}
+		if !hc.bodyRegex.Match(body) {
+			return fmt.Errorf("response body did not match %s", hc.bodyRegex.String())
+		}
+	}
+	return nil
+}
+
+// RecordPassive absorbs a live proxied request's outcome: a 5xx status or a
+// dial error counts as a failure toward ejection, anything else as a
+// success that resets the failure count.
+func (hc *HealthChecker) RecordPassive(statusCode int, err error) {
+	if err != nil || statusCode >= http.StatusInternalServerError {
+		hc.recordFailure()
+		return
+	}
+	hc.recordSuccess()
+}
+
+func (hc *HealthChecker) recordSuccess() {
+	hc.server.Mutex.Lock()
+	wasEjected := !hc.server.ejectedUntil.IsZero()
+	hc.server.consecutiveFailures = 0
+	hc.server.ejectedUntil = time.Time{}
+	hc.server.backoff = 0
+	hc.server.isHealthy = true
+	hc.server.Mutex.Unlock()
+
+	if wasEjected {
+		log.Printf("%s recovered, reinstating", hc.server.URL)
+	}
+}
+
+func (hc *HealthChecker) recordFailure() {
+	hc.server.Mutex.Lock()
+	hc.server.consecutiveFailures++
+	failures := hc.server.consecutiveFailures
+
+	// A half-open probe (ejectedUntil already in the past) that fails must
+	// re-eject with a doubled backoff, not leave ejectedUntil stale forever
+	// — otherwise probe() stops waiting at all after the first ejection.
+	stillCoolingDown := !hc.server.ejectedUntil.IsZero() && time.Now().Before(hc.server.ejectedUntil)
+
+	var eject bool
+	if failures >= hc.passiveFailureLimit && !stillCoolingDown {
+		eject = true
+		if hc.server.backoff == 0 {
+			hc.server.backoff = hc.ejectBackoff
+		} else {
+			hc.server.backoff *= 2
+			if hc.server.backoff > hc.ejectMaxBackoff {
+				hc.server.backoff = hc.ejectMaxBackoff
+			}
+		}
+		hc.server.ejectedUntil = time.Now().Add(hc.server.backoff)
+		hc.server.isHealthy = false
+	}
+	hc.server.Mutex.Unlock()
+
+	switch {
+	case eject:
+		log.Printf("SEVERITY=critical: ejecting %s after %d consecutive failures, half-open probe in %s", hc.server.URL, failures, hc.server.backoff)
+	case failures > 1:
+		log.Printf("SEVERITY=warning: %s has failed %d consecutive health checks", hc.server.URL, failures)
+	default:
+		log.Printf("SEVERITY=info: %s failed a health check", hc.server.URL)
+	}
+}
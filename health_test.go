@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestHealthChecker(t *testing.T, limit int, backoff, maxBackoff string) (*Server, *HealthChecker) {
+	t.Helper()
+	server := &Server{URL: &url.URL{Scheme: "http", Host: "backend.invalid"}, isHealthy: true}
+	hc, err := NewHealthChecker(server, HealthCheckConfig{
+		PassiveFailureLimit: limit,
+		EjectBackoff:        backoff,
+		EjectMaxBackoff:     maxBackoff,
+	}, time.Second)
+	if err != nil {
+		t.Fatalf("NewHealthChecker: %v", err)
+	}
+	return server, hc
+}
+
+func TestRecordFailureEjectsAfterLimit(t *testing.T) {
+	server, hc := newTestHealthChecker(t, 2, "10ms", "100ms")
+
+	hc.recordFailure()
+	if !server.isHealthy {
+		t.Fatalf("server should still be healthy after one failure below the limit")
+	}
+
+	hc.recordFailure()
+	if server.isHealthy {
+		t.Fatalf("server should be ejected after reaching the failure limit")
+	}
+	if server.ejectedUntil.IsZero() || !server.ejectedUntil.After(time.Now()) {
+		t.Fatalf("ejectedUntil should be set in the future")
+	}
+}
+
+// TestRecordFailureReEjectsOnFailedHalfOpenProbe guards against the backoff
+// only ever being applied on the very first ejection: once ejectedUntil has
+// passed, a failing half-open probe must re-eject with a doubled backoff
+// rather than leaving a stale, already-past ejectedUntil in place.
+func TestRecordFailureReEjectsOnFailedHalfOpenProbe(t *testing.T) {
+	server, hc := newTestHealthChecker(t, 1, "10ms", "100ms")
+
+	hc.recordFailure()
+	firstBackoff := server.backoff
+	firstEjectedUntil := server.ejectedUntil
+	if firstBackoff != 10*time.Millisecond {
+		t.Fatalf("expected initial backoff of 10ms, got %s", firstBackoff)
+	}
+
+	time.Sleep(15 * time.Millisecond)
+	if time.Now().Before(server.ejectedUntil) {
+		t.Fatalf("test setup error: ejection window should have elapsed")
+	}
+
+	hc.recordFailure()
+	if server.backoff != 2*firstBackoff {
+		t.Fatalf("expected backoff to double to %s, got %s", 2*firstBackoff, server.backoff)
+	}
+	if !server.ejectedUntil.After(firstEjectedUntil) {
+		t.Fatalf("expected ejectedUntil to advance past the elapsed window, got %s (was %s)", server.ejectedUntil, firstEjectedUntil)
+	}
+	if !server.ejectedUntil.After(time.Now()) {
+		t.Fatalf("server should be back in an active cooldown after the failed half-open probe")
+	}
+}
+
+func TestRecordSuccessResetsCircuitBreaker(t *testing.T) {
+	server, hc := newTestHealthChecker(t, 1, "10ms", "100ms")
+
+	hc.recordFailure()
+	if server.isHealthy {
+		t.Fatalf("server should be ejected")
+	}
+
+	hc.recordSuccess()
+	if !server.isHealthy {
+		t.Fatalf("server should be healthy again after a success")
+	}
+	if server.consecutiveFailures != 0 || !server.ejectedUntil.IsZero() || server.backoff != 0 {
+		t.Fatalf("recordSuccess should clear consecutiveFailures, ejectedUntil and backoff")
+	}
+}
+
+// TestCheckHTTPMatchesBodyRegexPastFirstReadChunk guards against trusting a
+// single Read call to fill the whole body: the marker sits after byte 4096,
+// where the old fixed-size-buffer implementation would never see it.
+func TestCheckHTTPMatchesBodyRegexPastFirstReadChunk(t *testing.T) {
+	body := strings.Repeat("a", 5000) + "MARKER"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	server := &Server{URL: mustParseURL(t, ts.URL), isHealthy: true}
+	hc, err := NewHealthChecker(server, HealthCheckConfig{
+		Method:            http.MethodGet,
+		ExpectedBodyRegex: "MARKER",
+	}, time.Second)
+	if err != nil {
+		t.Fatalf("NewHealthChecker: %v", err)
+	}
+
+	if err := hc.checkHTTP(); err != nil {
+		t.Fatalf("expected the body regex to match a marker past the first 4096 bytes, got: %v", err)
+	}
+}
+
+func TestCheckHTTPFailsWhenBodyRegexDoesNotMatch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("nothing interesting here"))
+	}))
+	defer ts.Close()
+
+	server := &Server{URL: mustParseURL(t, ts.URL), isHealthy: true}
+	hc, err := NewHealthChecker(server, HealthCheckConfig{
+		Method:            http.MethodGet,
+		ExpectedBodyRegex: "MARKER",
+	}, time.Second)
+	if err != nil {
+		t.Fatalf("NewHealthChecker: %v", err)
+	}
+
+	if err := hc.checkHTTP(); err == nil {
+		t.Fatalf("expected an error when the body doesn't match the regex")
+	}
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("url.Parse(%q): %v", raw, err)
+	}
+	return u
+}
+
+func TestRecordFailureCapsAtMaxBackoff(t *testing.T) {
+	server, hc := newTestHealthChecker(t, 1, "10ms", "15ms")
+
+	hc.recordFailure()
+	time.Sleep(15 * time.Millisecond)
+	hc.recordFailure() // would double to 20ms, capped at 15ms
+	if server.backoff != 15*time.Millisecond {
+		t.Fatalf("expected backoff capped at 15ms, got %s", server.backoff)
+	}
+}
@@ -1,34 +1,130 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Load-balancer
-type LoadBalancer struct {
-	Current int // current server index
-	Mutex   sync.Mutex
-}
-
 // Server
 type Server struct {
 	URL       *url.URL
+	Address   string // host:port, used by L4 frontends
+	Kind      string // "http" | "tcp"
 	isHealthy bool
 	Mutex     sync.Mutex
+
+	inFlight  int64   // atomic: requests currently being proxied to this server, across all policies
+	ewma      float64 // exponentially-weighted moving average latency, in seconds
+	ewmaMutex sync.Mutex
+
+	// Circuit-breaker state, guarded by Mutex.
+	consecutiveFailures int
+	ejectedUntil        time.Time
+	backoff             time.Duration
+
+	// Admin-driven state, guarded by Mutex.
+	draining bool // stop selecting for new requests; in-flight ones finish
+	disabled bool // manually forced down; health checks won't re-enable it
+
+	healthChecker   *HealthChecker
+	stopHealthCheck chan struct{} // closed once this backend is drained and removed on reload
+
+	connLimiter *connLimiter // enforces PoolConfig.MaxConns, nil when unlimited
+}
+
+// Selectable reports whether s may be chosen for a new request: healthy,
+// not manually disabled, and not draining.
+func (s *Server) Selectable() bool {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	return s.isHealthy && !s.disabled && !s.draining
+}
+
+// InFlight returns the number of requests currently in-flight to s.
+func (s *Server) InFlight() int64 {
+	return atomic.LoadInt64(&s.inFlight)
+}
+
+func (s *Server) incInFlight() {
+	atomic.AddInt64(&s.inFlight, 1)
+	inFlightGauge.WithLabelValues(serverID(s)).Inc()
+}
+
+func (s *Server) decInFlight() {
+	atomic.AddInt64(&s.inFlight, -1)
+	inFlightGauge.WithLabelValues(serverID(s)).Dec()
+}
+
+// EWMA returns the current exponentially-weighted moving average latency,
+// in seconds.
+func (s *Server) EWMA() float64 {
+	s.ewmaMutex.Lock()
+	defer s.ewmaMutex.Unlock()
+	return s.ewma
+}
+
+func (s *Server) updateEWMA(latency time.Duration, alpha float64) {
+	sample := latency.Seconds()
+
+	s.ewmaMutex.Lock()
+	defer s.ewmaMutex.Unlock()
+	if s.ewma == 0 {
+		s.ewma = sample
+		return
+	}
+	s.ewma = alpha*sample + (1-alpha)*s.ewma
 }
 
 type Config struct {
-	Port                string   `json:"port"`
-	HealthCheckInterval string   `json:"healthCheckInterval"`
-	Servers             []string `json:"servers"`
+	Port                string                       `json:"port"`
+	HealthCheckInterval string                       `json:"healthCheckInterval"`
+	Servers             []string                     `json:"servers"`
+	Strategy            string                       `json:"strategy"`
+	Persistence         PersistenceConfig            `json:"persistence"`
+	HealthChecks        map[string]HealthCheckConfig `json:"healthChecks"` // keyed by backend URL, as given in Servers
+	Frontends           []FrontendConfig             `json:"frontends"`    // additional L4 listeners served alongside the L7 frontend
+
+	Pools       map[string]PoolConfig `json:"pools"`       // named upstream pools for the routing layer
+	Routes      []RouteConfig         `json:"routes"`       // ordered host/path/header matchers, evaluated before falling back to DefaultPool
+	DefaultPool string                `json:"defaultPool"` // pool used when no route matches; defaults to "default"
+
+	AdminPort string `json:"adminPort"` // separate listener for the admin API; admin API disabled when empty
+}
+
+// buildPools returns the configured upstream pools, falling back to a
+// single "default" pool built from the legacy top-level Servers/Strategy/
+// Persistence/HealthChecks fields when Pools isn't set.
+func (c Config) buildPools(defaultInterval time.Duration) (map[string]*Pool, error) {
+	if len(c.Pools) == 0 {
+		pool, err := NewPool("default", PoolConfig{
+			Servers:      c.Servers,
+			Strategy:     c.Strategy,
+			HealthChecks: c.HealthChecks,
+			Persistence:  c.Persistence,
+		}, defaultInterval)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]*Pool{"default": pool}, nil
+	}
+
+	pools := make(map[string]*Pool, len(c.Pools))
+	for name, cfg := range c.Pools {
+		pool, err := NewPool(name, cfg, defaultInterval)
+		if err != nil {
+			return nil, err
+		}
+		pools[name] = pool
+	}
+	return pools, nil
 }
 
 func main() {
@@ -42,74 +138,131 @@ func main() {
 		log.Fatal("Invalid health check interval")
 	}
 
-	var servers []*Server
-	for _, serverURL := range config.Servers {
-		u, _ := url.Parse(serverURL)
-		server := &Server{URL: u, isHealthy: true}
-		servers = append(servers, server)
-		go CheckHealth(server, healthCheckInterval)
+	pools, err := config.buildPools(healthCheckInterval)
+	if err != nil {
+		log.Fatalf("Error building upstream pools: %s\n", err.Error())
+	}
+
+	defaultPoolName := config.DefaultPool
+	if defaultPoolName == "" {
+		defaultPoolName = "default"
+	}
+	defaultPool, ok := pools[defaultPoolName]
+	if !ok {
+		log.Fatalf("Default pool %q not found\n", defaultPoolName)
+	}
+
+	router, err := NewRouter(config.Routes, pools, defaultPool)
+	if err != nil {
+		log.Fatalf("Error building routes: %s\n", err.Error())
 	}
 
-	lb := LoadBalancer{Current: 0}
+	state := &ProxyState{pools: pools, router: router}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		router, _ := state.snapshot()
+		route, pool := router.Match(r)
+		routeName := "default"
+		if route != nil {
+			routeName = route.name
+			if !route.Allow() {
+				rateLimitedTotal.WithLabelValues(routeName).Inc()
+				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			route.Rewrite(r)
+		} else {
+			// No configured route matched; still a front door, so the
+			// standard forwarding headers always apply.
+			setForwardingHeaders(r)
+		}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		server := lb.getNextServer(servers)
+		server := pool.Pick(w, r)
 		if server == nil {
 			http.Error(w, "no healthy server available", http.StatusServiceUnavailable)
 			return
 		}
 		w.Header().Add("X-Forwarded Server", server.URL.String())
-		server.ReverseProxy().ServeHTTP(w, r)
 
+		acquired := server.connLimiter.TryAcquire()
+		if !acquired && pool.QueueTimeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), pool.QueueTimeout)
+			defer cancel()
+			acquired = server.connLimiter.Acquire(ctx)
+		}
+		if !acquired {
+			capacityRejectedTotal.WithLabelValues(serverID(server)).Inc()
+			http.Error(w, "backend at capacity", http.StatusServiceUnavailable)
+			return
+		}
+		defer server.connLimiter.Release()
+
+		server.incInFlight()
+		pool.Policy.OnRequestStart(server)
+		start := time.Now()
+		sw := &statusCapturingWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		defer func() {
+			latency := time.Since(start)
+			pool.Policy.OnRequestEnd(server, latency, nil)
+			server.decInFlight()
+			server.healthChecker.RecordPassive(sw.statusCode, nil)
+			observeRequest(routeName, serverID(server), sw.statusCode, latency)
+		}()
+		server.ReverseProxy().ServeHTTP(sw, r)
 	})
-	log.Println("Starting load balancer on port", config.Port)
-	err = http.ListenAndServe(config.Port, nil)
-	if err != nil {
-		log.Fatalf("Error starting load balancer: %s\n", err.Error())
-	}
-}
+	httpServer := &http.Server{Addr: config.Port, Handler: mux}
+
+	var frontends []Frontend
+	for _, frontendCfg := range config.Frontends {
+		frontend, err := NewFrontend(frontendCfg)
+		if err != nil {
+			log.Fatalf("Invalid frontend %q: %s\n", frontendCfg.Name, err.Error())
+		}
+		frontends = append(frontends, frontend)
 
-// load balancer algorithm
-func (lb *LoadBalancer) getNextServer(servers []*Server) *Server {
-	lb.Mutex.Lock()
-	defer lb.Mutex.Unlock()
-
-	// loop to find healthy server
-	for i := 0; i < len(servers); i++ {
-		server := servers[lb.Current]
-		lb.Current = (lb.Current + 1) % len(servers)
-
-		// check if server is healthy
-		server.Mutex.Lock()
-		if server.isHealthy {
-			server.Mutex.Unlock()
-			return server
+		if l4, ok := frontend.(*L4Frontend); ok {
+			for sniHost, backends := range l4.Backends() {
+				label := "frontend:" + frontendCfg.Name
+				if sniHost != "" {
+					label += ":" + sniHost
+				}
+				state.registerFrontendBackends(label, backends)
+			}
 		}
-		server.Mutex.Unlock()
 
+		go func(name, addr string, f Frontend) {
+			log.Printf("Starting %s frontend on %s", name, addr)
+			if err := f.Serve(); err != nil {
+				log.Printf("frontend %q stopped: %v", name, err)
+			}
+		}(frontendCfg.Name, frontendCfg.ListenAddr, frontend)
 	}
-	return nil
 
-}
+	shutdownServers := []*http.Server{httpServer}
 
-func CheckHealth(s *Server, healthCheckInterval time.Duration) {
-	for range time.Tick(healthCheckInterval) {
-		// head request  to server
-		res, err := http.Head(s.URL.String())
+	// The admin API listener is optional, but SIGHUP reload and SIGTERM/
+	// SIGINT graceful shutdown apply to every deployment regardless.
+	admin := NewAdminServer(state, "config.json", healthCheckInterval)
 
-		s.Mutex.Lock()
-		if err != nil || res.StatusCode != http.StatusOK {
-			fmt.Printf("%s is down\n", s.URL)
-			s.isHealthy = false
-		} else {
-			s.isHealthy = true
-		}
-		s.Mutex.Unlock()
-		// close the response body using condn [runtime error if res is  nil as we cant access it]
-		if res != nil {
-			res.Body.Close()
-		}
+	if config.AdminPort != "" {
+		adminServer := &http.Server{Addr: config.AdminPort, Handler: admin.Handler()}
+		shutdownServers = append(shutdownServers, adminServer)
+
+		go func() {
+			log.Println("Starting admin API on port", config.AdminPort)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("admin server stopped: %v", err)
+			}
+		}()
+	}
+
+	go HandleShutdownSignals(admin, state, shutdownServers, frontends)
 
+	log.Println("Starting load balancer on port", config.Port)
+	err = httpServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Error starting load balancer: %s\n", err.Error())
 	}
 }
 
@@ -117,6 +270,18 @@ func (s *Server) ReverseProxy() *httputil.ReverseProxy {
 	return httputil.NewSingleHostReverseProxy(s.URL)
 }
 
+// statusCapturingWriter records the status code written through it so
+// passive health checks can observe the outcome of a proxied request.
+type statusCapturingWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (w *statusCapturingWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
 func loadConfig(file string) (Config, error) {
 	var config Config
 	data, err := os.ReadFile(file)
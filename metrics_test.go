@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConnLimiterTryAcquireRejectsWhenFull(t *testing.T) {
+	l := newConnLimiter(1)
+
+	if !l.TryAcquire() {
+		t.Fatalf("expected the first TryAcquire to succeed")
+	}
+	if l.TryAcquire() {
+		t.Fatalf("expected TryAcquire to fail once the single slot is held")
+	}
+
+	l.Release()
+	if !l.TryAcquire() {
+		t.Fatalf("expected TryAcquire to succeed again after Release")
+	}
+}
+
+func TestConnLimiterAcquireBlocksUntilReleaseOrTimeout(t *testing.T) {
+	l := newConnLimiter(1)
+	if !l.TryAcquire() {
+		t.Fatalf("expected to acquire the only slot")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if l.Acquire(ctx) {
+		t.Fatalf("expected Acquire to time out while the slot is held")
+	}
+
+	l.Release()
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Second)
+	defer cancel2()
+	if !l.Acquire(ctx2) {
+		t.Fatalf("expected Acquire to succeed once the slot was released")
+	}
+}
+
+func TestConnLimiterNilIsUnlimited(t *testing.T) {
+	var l *connLimiter
+	if !l.TryAcquire() {
+		t.Fatalf("a nil connLimiter should never reject TryAcquire")
+	}
+	if !l.Acquire(context.Background()) {
+		t.Fatalf("a nil connLimiter should never reject Acquire")
+	}
+	l.Release() // must not panic
+}
+
+func TestConnLimiterConcurrentAcquireOnlyOneSlotHolder(t *testing.T) {
+	l := newConnLimiter(1)
+	const attempts = 10
+
+	var succeeded int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if l.TryAcquire() {
+				atomic.AddInt32(&succeeded, 1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if succeeded != 1 {
+		t.Fatalf("expected exactly one TryAcquire to succeed against a 1-slot limiter, got %d", succeeded)
+	}
+}
+
+func TestRouteAllowRateLimits(t *testing.T) {
+	rt, err := NewRoute(RouteConfig{
+		RateLimit: &RateLimitConfig{RPS: 1000, Burst: 2},
+	}, fixturePool("default"))
+	if err != nil {
+		t.Fatalf("NewRoute: %v", err)
+	}
+
+	if !rt.Allow() {
+		t.Fatalf("expected the first request within burst to be allowed")
+	}
+	if !rt.Allow() {
+		t.Fatalf("expected the second request within burst to be allowed")
+	}
+	if rt.Allow() {
+		t.Fatalf("expected a request beyond the configured burst to be rejected")
+	}
+}
+
+func TestRouteAllowWithNoRateLimitConfigured(t *testing.T) {
+	rt, err := NewRoute(RouteConfig{}, fixturePool("default"))
+	if err != nil {
+		t.Fatalf("NewRoute: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if !rt.Allow() {
+			t.Fatalf("a route with no rate limit configured should always allow")
+		}
+	}
+}
+
+func TestRouteAllowRefillsOverTime(t *testing.T) {
+	rt, err := NewRoute(RouteConfig{
+		RateLimit: &RateLimitConfig{RPS: 100, Burst: 1},
+	}, fixturePool("default"))
+	if err != nil {
+		t.Fatalf("NewRoute: %v", err)
+	}
+
+	if !rt.Allow() {
+		t.Fatalf("expected the first request to be allowed")
+	}
+	if rt.Allow() {
+		t.Fatalf("expected the burst to be exhausted immediately after")
+	}
+
+	time.Sleep(20 * time.Millisecond) // at 100rps a token refills every 10ms; Burst 1 caps the bucket at one
+	if !rt.Allow() {
+		t.Fatalf("expected the limiter to have refilled a token after waiting")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	"log"
+	"net"
+	"net/http"
+)
+
+// Persistence sits in front of the BalancingPolicy and routes a client back
+// to the backend it was previously bound to, falling back to the base
+// policy when no binding applies or the bound backend is no longer healthy.
+type Persistence interface {
+	// Select returns the server r is already bound to, or nil to fall back
+	// to the BalancingPolicy.
+	Select(servers []*Server, r *http.Request) *Server
+	// Bind records that server handled r, e.g. by setting a cookie.
+	Bind(w http.ResponseWriter, r *http.Request, server *Server)
+}
+
+// PersistenceConfig configures the Persistence subsystem.
+type PersistenceConfig struct {
+	Mode       string `json:"mode"` // "" | "source_ip" | "cookie"
+	CookieName string `json:"cookieName"`
+	Secret     string `json:"secret"`
+}
+
+// NewPersistence builds the Persistence named by cfg.Mode, or nil when no
+// persistence is configured.
+func NewPersistence(cfg PersistenceConfig) Persistence {
+	switch cfg.Mode {
+	case "source_ip":
+		return &SourceIPPersistence{}
+	case "cookie":
+		name := cfg.CookieName
+		if name == "" {
+			name = "GOBALANCER_SERVER"
+		}
+		return &CookiePersistence{cookieName: name, secret: []byte(cfg.Secret)}
+	case "":
+		return nil
+	default:
+		log.Printf("unknown persistence mode %q, disabling persistence", cfg.Mode)
+		return nil
+	}
+}
+
+// SourceIPPersistence hashes the client IP with FNV-1a modulo the server
+// count to consistently pick the same backend for a given client.
+type SourceIPPersistence struct{}
+
+func (p *SourceIPPersistence) Select(servers []*Server, r *http.Request) *Server {
+	if len(servers) == 0 {
+		return nil
+	}
+
+	ip := clientIP(r)
+	h := fnv.New32a()
+	h.Write([]byte(ip))
+	index := int(h.Sum32()) % len(servers)
+	if index < 0 {
+		index += len(servers)
+	}
+
+	server := servers[index]
+	if !server.Selectable() {
+		return nil
+	}
+	return server
+}
+
+func (p *SourceIPPersistence) Bind(w http.ResponseWriter, r *http.Request, server *Server) {}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// CookiePersistence binds a client to a backend by setting an HMAC-signed
+// cookie carrying the backend's identifier; subsequent requests are routed
+// to that backend as long as the signature verifies and it's still healthy.
+type CookiePersistence struct {
+	cookieName string
+	secret     []byte
+}
+
+func (p *CookiePersistence) Select(servers []*Server, r *http.Request) *Server {
+	cookie, err := r.Cookie(p.cookieName)
+	if err != nil {
+		return nil
+	}
+
+	id, ok := p.verify(cookie.Value)
+	if !ok {
+		return nil
+	}
+
+	for _, server := range servers {
+		if serverID(server) != id {
+			continue
+		}
+		if !server.Selectable() {
+			return nil
+		}
+		return server
+	}
+	return nil
+}
+
+func (p *CookiePersistence) Bind(w http.ResponseWriter, r *http.Request, server *Server) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     p.cookieName,
+		Value:    p.sign(serverID(server)),
+		Path:     "/",
+		HttpOnly: true,
+	})
+}
+
+// sign returns "<id>.<hmac-hex>".
+func (p *CookiePersistence) sign(id string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(id))
+	return id + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature produced by sign and returns the backend id.
+func (p *CookiePersistence) verify(value string) (string, bool) {
+	sep := len(value) - 1
+	for sep >= 0 && value[sep] != '.' {
+		sep--
+	}
+	if sep < 0 {
+		return "", false
+	}
+	id, sig := value[:sep], value[sep+1:]
+
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(id))
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return "", false
+	}
+	return id, true
+}
+
+// serverID is the stable identifier used to refer to a backend across
+// requests; the backend URL is unique within a pool.
+func serverID(s *Server) string {
+	return s.URL.String()
+}
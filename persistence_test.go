@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func newTestBackend(host string, healthy bool) *Server {
+	return &Server{URL: &url.URL{Scheme: "http", Host: host}, isHealthy: healthy}
+}
+
+func TestSourceIPPersistenceIsStableForSameClient(t *testing.T) {
+	servers := []*Server{
+		newTestBackend("a.invalid", true),
+		newTestBackend("b.invalid", true),
+		newTestBackend("c.invalid", true),
+	}
+	p := &SourceIPPersistence{}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.7:54321"
+
+	first := p.Select(servers, r)
+	if first == nil {
+		t.Fatalf("expected a server to be selected")
+	}
+	for i := 0; i < 5; i++ {
+		if got := p.Select(servers, r); got != first {
+			t.Fatalf("Select #%d returned a different backend for the same client IP", i)
+		}
+	}
+}
+
+func TestSourceIPPersistenceSkipsUnhealthyBoundServer(t *testing.T) {
+	servers := []*Server{newTestBackend("a.invalid", false)}
+	p := &SourceIPPersistence{}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.7:54321"
+
+	if got := p.Select(servers, r); got != nil {
+		t.Fatalf("expected nil when the only bound backend is unhealthy, got %v", got)
+	}
+}
+
+func TestCookiePersistenceRoundTrip(t *testing.T) {
+	servers := []*Server{newTestBackend("a.invalid", true), newTestBackend("b.invalid", true)}
+	p := &CookiePersistence{cookieName: "GOBALANCER_SERVER", secret: []byte("test-secret")}
+
+	w := httptest.NewRecorder()
+	p.Bind(w, nil, servers[1])
+
+	result := w.Result()
+	cookies := result.Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected exactly one cookie to be set, got %d", len(cookies))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+
+	if got := p.Select(servers, r); got != servers[1] {
+		t.Fatalf("Select did not route back to the bound backend, got %v", got)
+	}
+}
+
+func TestCookiePersistenceRejectsTamperedCookie(t *testing.T) {
+	servers := []*Server{newTestBackend("a.invalid", true), newTestBackend("b.invalid", true)}
+	p := &CookiePersistence{cookieName: "GOBALANCER_SERVER", secret: []byte("test-secret")}
+
+	w := httptest.NewRecorder()
+	p.Bind(w, nil, servers[0])
+	cookie := w.Result().Cookies()[0]
+	cookie.Value = cookie.Value[:len(cookie.Value)-1] + "0" // flip the last hex digit of the signature
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+
+	if got := p.Select(servers, r); got != nil {
+		t.Fatalf("expected nil for a tampered signature, got %v", got)
+	}
+}
+
+func TestCookiePersistenceDifferentSecretRejectsCookie(t *testing.T) {
+	servers := []*Server{newTestBackend("a.invalid", true)}
+
+	signer := &CookiePersistence{cookieName: "GOBALANCER_SERVER", secret: []byte("secret-a")}
+	verifier := &CookiePersistence{cookieName: "GOBALANCER_SERVER", secret: []byte("secret-b")}
+
+	w := httptest.NewRecorder()
+	signer.Bind(w, nil, servers[0])
+	cookie := w.Result().Cookies()[0]
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+
+	if got := verifier.Select(servers, r); got != nil {
+		t.Fatalf("expected nil when verifying with a different secret, got %v", got)
+	}
+}
+
+func TestNewPersistenceUnknownModeDisables(t *testing.T) {
+	if got := NewPersistence(PersistenceConfig{Mode: "bogus"}); got != nil {
+		t.Fatalf("expected nil persistence for an unknown mode, got %v", got)
+	}
+	if got := NewPersistence(PersistenceConfig{Mode: ""}); got != nil {
+		t.Fatalf("expected nil persistence for an empty mode, got %v", got)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Pool is a named group of backends sharing a balancing policy, health
+// checks and persistence. Routes select a Pool; the default pool is used
+// when no route matches.
+type Pool struct {
+	Name         string
+	Servers      []*Server
+	Policy       BalancingPolicy
+	Persistence  Persistence
+	QueueTimeout time.Duration // how long a request waits for a MaxConns slot; zero means fail fast
+}
+
+// PoolConfig configures one upstream pool.
+type PoolConfig struct {
+	Servers      []string                     `json:"servers"`
+	Strategy     string                       `json:"strategy"`
+	HealthChecks map[string]HealthCheckConfig `json:"healthChecks"` // keyed by backend URL, as given in Servers
+	Persistence  PersistenceConfig            `json:"persistence"`
+	MaxConns     map[string]int               `json:"maxConns"`     // per-backend concurrency cap, keyed by backend URL; 0/absent means unlimited
+	QueueTimeout string                       `json:"queueTimeout"` // how long a request waits for a slot under MaxConns before being rejected
+}
+
+// NewPool builds a Pool from cfg, starting an active health checker for
+// every backend.
+func NewPool(name string, cfg PoolConfig, defaultInterval time.Duration) (*Pool, error) {
+	queueTimeout, err := parseDurationOrDefault(cfg.QueueTimeout, 0)
+	if err != nil {
+		return nil, fmt.Errorf("pool %q: invalid queueTimeout: %w", name, err)
+	}
+
+	pool := &Pool{
+		Name:         name,
+		Policy:       NewBalancingPolicy(cfg.Strategy),
+		Persistence:  NewPersistence(cfg.Persistence),
+		QueueTimeout: queueTimeout,
+	}
+
+	for _, serverURL := range cfg.Servers {
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			return nil, fmt.Errorf("pool %q: invalid server URL %q: %w", name, serverURL, err)
+		}
+		server := &Server{
+			URL:             u,
+			Kind:            "http",
+			isHealthy:       true,
+			stopHealthCheck: make(chan struct{}),
+			connLimiter:     newConnLimiter(cfg.MaxConns[serverURL]),
+		}
+
+		hc, err := NewHealthChecker(server, cfg.HealthChecks[serverURL], defaultInterval)
+		if err != nil {
+			return nil, fmt.Errorf("pool %q: %w", name, err)
+		}
+		server.healthChecker = hc
+		go hc.Run(server.stopHealthCheck)
+
+		pool.Servers = append(pool.Servers, server)
+	}
+	return pool, nil
+}
+
+// Pick selects a backend for r, consulting persistence before falling back
+// to the pool's balancing policy, and binds the choice for future requests.
+func (p *Pool) Pick(w http.ResponseWriter, r *http.Request) *Server {
+	var server *Server
+	if p.Persistence != nil {
+		server = p.Persistence.Select(p.Servers, r)
+	}
+	if server == nil {
+		server = p.Policy.Pick(p.Servers, r)
+	}
+	if server != nil && p.Persistence != nil {
+		p.Persistence.Bind(w, r, server)
+	}
+	return server
+}
+
+// RateLimitConfig configures a token-bucket rate limiter for a route.
+type RateLimitConfig struct {
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+}
+
+// RouteConfig matches requests on host/path/method/headers and dispatches
+// them to a named pool, applying the given rewrites.
+type RouteConfig struct {
+	Name          string            `json:"name"` // used to label metrics and logs; defaults to "<pool><pathPrefix>"
+	Host          string            `json:"host"` // regex, matched against r.Host
+	PathPrefix    string            `json:"pathPrefix"`
+	Method        string            `json:"method"`
+	Headers       map[string]string `json:"headers"` // header name -> regex
+	Pool          string            `json:"pool"`
+	StripPrefix   bool              `json:"stripPrefix"`
+	SetHeaders    map[string]string `json:"setHeaders"`
+	RemoveHeaders []string          `json:"removeHeaders"`
+	HostOverride  string            `json:"hostOverride"`
+	RateLimit     *RateLimitConfig  `json:"rateLimit,omitempty"`
+}
+
+// Route is a RouteConfig compiled once at load time.
+type Route struct {
+	name          string
+	hostRegex     *regexp.Regexp
+	pathPrefix    string
+	method        string
+	headerRegexes map[string]*regexp.Regexp
+
+	pool *Pool
+
+	stripPrefix   bool
+	setHeaders    map[string]string
+	removeHeaders []string
+	hostOverride  string
+
+	limiter *rate.Limiter
+}
+
+// NewRoute compiles cfg against the given pool.
+func NewRoute(cfg RouteConfig, pool *Pool) (*Route, error) {
+	rt := &Route{
+		pathPrefix:    cfg.PathPrefix,
+		method:        cfg.Method,
+		pool:          pool,
+		stripPrefix:   cfg.StripPrefix,
+		setHeaders:    cfg.SetHeaders,
+		removeHeaders: cfg.RemoveHeaders,
+		hostOverride:  cfg.HostOverride,
+	}
+	rt.name = routeName(cfg)
+
+	if cfg.RateLimit != nil {
+		rt.limiter = rate.NewLimiter(rate.Limit(cfg.RateLimit.RPS), cfg.RateLimit.Burst)
+	}
+
+	if cfg.Host != "" {
+		re, err := regexp.Compile(cfg.Host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host pattern %q: %w", cfg.Host, err)
+		}
+		rt.hostRegex = re
+	}
+
+	if len(cfg.Headers) > 0 {
+		rt.headerRegexes = make(map[string]*regexp.Regexp, len(cfg.Headers))
+		for name, pattern := range cfg.Headers {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid header pattern for %q: %w", name, err)
+			}
+			rt.headerRegexes[name] = re
+		}
+	}
+
+	return rt, nil
+}
+
+// routeName returns cfg.Name, falling back to a value derived from its
+// pool and path prefix so every route has a usable metrics/log label.
+func routeName(cfg RouteConfig) string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+	return cfg.Pool + cfg.PathPrefix
+}
+
+// Allow reports whether a request against rt's rate limiter may proceed.
+// Routes with no configured limit always allow.
+func (rt *Route) Allow() bool {
+	if rt.limiter == nil {
+		return true
+	}
+	return rt.limiter.Allow()
+}
+
+// Matches reports whether r satisfies every configured criterion of rt.
+func (rt *Route) Matches(r *http.Request) bool {
+	if rt.hostRegex != nil && !rt.hostRegex.MatchString(r.Host) {
+		return false
+	}
+	if rt.pathPrefix != "" && !strings.HasPrefix(r.URL.Path, rt.pathPrefix) {
+		return false
+	}
+	if rt.method != "" && !strings.EqualFold(rt.method, r.Method) {
+		return false
+	}
+	for name, re := range rt.headerRegexes {
+		if !re.MatchString(r.Header.Get(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Rewrite applies rt's header and path rewrites to r before it's proxied,
+// and sets the standard forwarding headers.
+func (rt *Route) Rewrite(r *http.Request) {
+	if rt.stripPrefix && rt.pathPrefix != "" {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, rt.pathPrefix)
+		if r.URL.Path == "" {
+			r.URL.Path = "/"
+		}
+	}
+	for name, value := range rt.setHeaders {
+		r.Header.Set(name, value)
+	}
+	for _, name := range rt.removeHeaders {
+		r.Header.Del(name)
+	}
+	if rt.hostOverride != "" {
+		r.Host = rt.hostOverride
+	}
+	setForwardingHeaders(r)
+}
+
+// setForwardingHeaders populates X-Forwarded-For, X-Forwarded-Proto and
+// X-Real-IP the way a front-door reverse proxy is expected to.
+func setForwardingHeaders(r *http.Request) {
+	ip := clientIP(r)
+
+	if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+		r.Header.Set("X-Forwarded-For", prior+", "+ip)
+	} else {
+		r.Header.Set("X-Forwarded-For", ip)
+	}
+	r.Header.Set("X-Real-IP", ip)
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	r.Header.Set("X-Forwarded-Proto", proto)
+}
+
+// Router holds the compiled, ordered route list plus the fall-through
+// default pool.
+type Router struct {
+	routes      []*Route
+	defaultPool *Pool
+}
+
+// NewRouter compiles routeCfgs against pools, in order, falling back to
+// defaultPool when nothing matches.
+func NewRouter(routeCfgs []RouteConfig, pools map[string]*Pool, defaultPool *Pool) (*Router, error) {
+	router := &Router{defaultPool: defaultPool}
+	for _, cfg := range routeCfgs {
+		pool, ok := pools[cfg.Pool]
+		if !ok {
+			return nil, fmt.Errorf("route references unknown pool %q", cfg.Pool)
+		}
+		rt, err := NewRoute(cfg, pool)
+		if err != nil {
+			return nil, err
+		}
+		router.routes = append(router.routes, rt)
+	}
+	return router, nil
+}
+
+// Match returns the first route matching r, or (nil, defaultPool) when
+// none do.
+func (router *Router) Match(r *http.Request) (*Route, *Pool) {
+	for _, rt := range router.routes {
+		if rt.Matches(r) {
+			return rt, rt.pool
+		}
+	}
+	return nil, router.defaultPool
+}
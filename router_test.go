@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteMatchesHostPathMethodHeaders(t *testing.T) {
+	pool := fixturePool("api")
+	rt, err := NewRoute(RouteConfig{
+		Host:       "^api\\.example\\.com$",
+		PathPrefix: "/v1/",
+		Method:     "post",
+		Headers:    map[string]string{"X-Api-Version": "^2\\."},
+	}, pool)
+	if err != nil {
+		t.Fatalf("NewRoute: %v", err)
+	}
+
+	newReq := func(host, path, method, apiVersion string) *http.Request {
+		r := httptest.NewRequest(method, path, nil)
+		r.Host = host
+		if apiVersion != "" {
+			r.Header.Set("X-Api-Version", apiVersion)
+		}
+		return r
+	}
+
+	cases := []struct {
+		name string
+		req  *http.Request
+		want bool
+	}{
+		{"all match", newReq("api.example.com", "/v1/widgets", http.MethodPost, "2.0"), true},
+		{"method differs but case-insensitive", newReq("api.example.com", "/v1/widgets", "POST", "2.0"), true},
+		{"wrong host", newReq("other.example.com", "/v1/widgets", http.MethodPost, "2.0"), false},
+		{"wrong path prefix", newReq("api.example.com", "/v2/widgets", http.MethodPost, "2.0"), false},
+		{"wrong method", newReq("api.example.com", "/v1/widgets", http.MethodGet, "2.0"), false},
+		{"missing header", newReq("api.example.com", "/v1/widgets", http.MethodPost, ""), false},
+		{"header does not match regex", newReq("api.example.com", "/v1/widgets", http.MethodPost, "1.0"), false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := rt.Matches(tc.req); got != tc.want {
+				t.Fatalf("Matches() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRouteMatchesWithNoCriteriaAlwaysMatches(t *testing.T) {
+	rt, err := NewRoute(RouteConfig{}, fixturePool("default"))
+	if err != nil {
+		t.Fatalf("NewRoute: %v", err)
+	}
+	if !rt.Matches(httptest.NewRequest(http.MethodGet, "/anything", nil)) {
+		t.Fatalf("a route with no criteria should match every request")
+	}
+}
+
+func TestRouteRewriteStripPrefixHeadersHostOverride(t *testing.T) {
+	rt, err := NewRoute(RouteConfig{
+		PathPrefix:    "/api",
+		StripPrefix:   true,
+		SetHeaders:    map[string]string{"X-Added": "yes"},
+		RemoveHeaders: []string{"X-Remove-Me"},
+		HostOverride:  "internal.backend.invalid",
+	}, fixturePool("default"))
+	if err != nil {
+		t.Fatalf("NewRoute: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/widgets", nil)
+	r.Host = "public.example.com"
+	r.Header.Set("X-Remove-Me", "gone")
+
+	rt.Rewrite(r)
+
+	if r.URL.Path != "/widgets" {
+		t.Fatalf("expected stripped path /widgets, got %s", r.URL.Path)
+	}
+	if r.Header.Get("X-Added") != "yes" {
+		t.Fatalf("expected X-Added header to be set")
+	}
+	if r.Header.Get("X-Remove-Me") != "" {
+		t.Fatalf("expected X-Remove-Me header to be removed")
+	}
+	if r.Host != "internal.backend.invalid" {
+		t.Fatalf("expected host override to take effect, got %s", r.Host)
+	}
+	if r.Header.Get("X-Forwarded-For") == "" {
+		t.Fatalf("expected Rewrite to also apply the standard forwarding headers")
+	}
+}
+
+func TestRouteRewriteStripPrefixToRootPath(t *testing.T) {
+	rt, err := NewRoute(RouteConfig{PathPrefix: "/api", StripPrefix: true}, fixturePool("default"))
+	if err != nil {
+		t.Fatalf("NewRoute: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api", nil)
+	rt.Rewrite(r)
+
+	if r.URL.Path != "/" {
+		t.Fatalf("expected stripping the whole path to leave \"/\", got %q", r.URL.Path)
+	}
+}
+
+func TestRouterMatchFirstMatchWins(t *testing.T) {
+	specific := fixturePool("specific")
+	general := fixturePool("general")
+	defaultPool := fixturePool("default")
+
+	router, err := NewRouter([]RouteConfig{
+		{Name: "general", PathPrefix: "/v1/", Pool: "general"},
+		{Name: "specific", PathPrefix: "/v1/special", Pool: "specific"},
+	}, map[string]*Pool{"general": general, "specific": specific}, defaultPool)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/special/widgets", nil)
+	route, pool := router.Match(r)
+	if route == nil || route.name != "general" {
+		t.Fatalf("expected the first configured route (%q) to win even though a later one also matches, got %v", "general", route)
+	}
+	if pool != general {
+		t.Fatalf("expected the general pool, got %v", pool)
+	}
+}
+
+func TestRouterMatchFallsBackToDefaultPool(t *testing.T) {
+	defaultPool := fixturePool("default")
+	api := fixturePool("api")
+
+	router, err := NewRouter([]RouteConfig{
+		{Name: "api", PathPrefix: "/api/", Pool: "api"},
+	}, map[string]*Pool{"api": api}, defaultPool)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	route, pool := router.Match(httptest.NewRequest(http.MethodGet, "/unmatched", nil))
+	if route != nil {
+		t.Fatalf("expected no route to match, got %v", route)
+	}
+	if pool != defaultPool {
+		t.Fatalf("expected the default pool when nothing matches, got %v", pool)
+	}
+}
+
+func TestNewRouterRejectsUnknownPool(t *testing.T) {
+	_, err := NewRouter([]RouteConfig{
+		{Name: "api", Pool: "does-not-exist"},
+	}, map[string]*Pool{}, fixturePool("default"))
+	if err == nil {
+		t.Fatalf("expected an error when a route references an unknown pool")
+	}
+}